@@ -0,0 +1,189 @@
+// fbinfogrid BME280 humidity and pressure sensor cells
+
+// Copyright ©2020 Steve Merrony
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/draw"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	i2cSlave          = 0x0703 // ioctl to set the address of the device on an I²C bus
+	bme280ChipID      = 0x60
+	bme280DefaultAddr = 0x76
+)
+
+// bme280ReadingT holds one set of compensated readings from a BME280
+type bme280ReadingT struct {
+	celsius  float64
+	pascals  float64
+	humidity float64 // percent relative humidity
+}
+
+// drawHumidity displays the relative humidity measured by a BME280
+func drawHumidity(ctx context.Context, wg *sync.WaitGroup, updateMu *sync.Mutex, cell CellT) {
+	drawBME280(ctx, updateMu, cell, func(r bme280ReadingT) string { return fmt.Sprintf("%.0f%%", r.humidity) })
+}
+
+// drawPressure displays the air pressure, in hectopascals (millibars), measured by a BME280
+func drawPressure(ctx context.Context, wg *sync.WaitGroup, updateMu *sync.Mutex, cell CellT) {
+	drawBME280(ctx, updateMu, cell, func(r bme280ReadingT) string { return fmt.Sprintf("%.0f hPa", r.pascals/100) })
+}
+
+// drawBME280 displays a value, formatted from a BME280 reading, after the cell's text if it has any;
+// the last good value is retained if the sensor cannot be read (eg. because it is not connected)
+func drawBME280(ctx context.Context, updateMu *sync.Mutex, cell CellT, format func(bme280ReadingT) string) {
+	reading, err := readBME280(ctx, cell.I2CBus, cell.i2cAddr)
+	if err != nil {
+		cellWarning(cell, "Could not read BME280 at address %#x on I2C bus %d due to %s", cell.i2cAddr, cell.I2CBus, err)
+	} else {
+		setLastText(cell, format(reading))
+	}
+	if cell.lastText == "" {
+		return // nothing to show yet
+	}
+	txt := cell.lastText
+	if cell.Text != "" {
+		txt = cell.Text + " " + txt
+	}
+	if unchanged(cell, []byte(txt)) {
+		return
+	}
+	updateMu.Lock()
+	draw.Draw(cell.picture, cell.picture.Bounds(), image.Black, image.ZP, draw.Src)
+	writeText(cell, cell.picture, txt)
+	render(cell.positionRect, cell.picture)
+	updateMu.Unlock()
+}
+
+// readBME280 takes a single (forced mode) measurement from a BME280 sensor on the given I²C bus,
+// compensated with the sensor's own calibration data as described in its datasheet
+func readBME280(ctx context.Context, bus int, addr uint16) (reading bme280ReadingT, err error) {
+	dev, err := os.OpenFile(fmt.Sprintf("/dev/i2c-%d", bus), os.O_RDWR, 0)
+	if err != nil {
+		return reading, err
+	}
+	defer dev.Close()
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, dev.Fd(), i2cSlave, uintptr(addr)); errno != 0 {
+		return reading, errno
+	}
+	readRegs := func(reg byte, n int) ([]byte, error) {
+		if _, err := dev.Write([]byte{reg}); err != nil {
+			return nil, err
+		}
+		buf := make([]byte, n)
+		_, err := dev.Read(buf)
+		return buf, err
+	}
+
+	id, err := readRegs(0xd0, 1)
+	if err != nil {
+		return reading, err
+	}
+	if id[0] != bme280ChipID {
+		return reading, fmt.Errorf("device is not a BME280 (chip id %#x)", id[0])
+	}
+	// oversample each measurement once, then start a measurement in forced mode
+	if _, err = dev.Write([]byte{0xf2, 0x01}); err != nil {
+		return reading, err
+	}
+	if _, err = dev.Write([]byte{0xf4, 0x25}); err != nil {
+		return reading, err
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return reading, ctx.Err()
+		case <-time.After(10 * time.Millisecond):
+		}
+		status, err := readRegs(0xf3, 1)
+		if err != nil {
+			return reading, err
+		}
+		if status[0]&0x08 == 0 {
+			break // measurement done
+		}
+	}
+	calib, err := readRegs(0x88, 26)
+	if err != nil {
+		return reading, err
+	}
+	calibH, err := readRegs(0xe1, 7)
+	if err != nil {
+		return reading, err
+	}
+	data, err := readRegs(0xf7, 8)
+	if err != nil {
+		return reading, err
+	}
+
+	u16 := func(b []byte) float64 { return float64(binary.LittleEndian.Uint16(b)) }
+	s16 := func(b []byte) float64 { return float64(int16(binary.LittleEndian.Uint16(b))) }
+	adcP := float64(uint32(data[0])<<12 | uint32(data[1])<<4 | uint32(data[2])>>4)
+	adcT := float64(uint32(data[3])<<12 | uint32(data[4])<<4 | uint32(data[5])>>4)
+	adcH := float64(uint32(data[6])<<8 | uint32(data[7]))
+
+	t1, t2, t3 := u16(calib[0:]), s16(calib[2:]), s16(calib[4:])
+	v1 := (adcT/16384 - t1/1024) * t2
+	v2 := (adcT/131072 - t1/8192) * (adcT/131072 - t1/8192) * t3
+	tFine := v1 + v2
+	reading.celsius = tFine / 5120
+
+	p1, p2, p3, p4, p5 := u16(calib[6:]), s16(calib[8:]), s16(calib[10:]), s16(calib[12:]), s16(calib[14:])
+	p6, p7, p8, p9 := s16(calib[16:]), s16(calib[18:]), s16(calib[20:]), s16(calib[22:])
+	v1 = tFine/2 - 64000
+	v2 = v1 * v1 * p6 / 32768
+	v2 += v1 * p5 * 2
+	v2 = v2/4 + p4*65536
+	v1 = (p3*v1*v1/524288 + p2*v1) / 524288
+	v1 = (1 + v1/32768) * p1
+	if v1 != 0 {
+		p := 1048576 - adcP
+		p = (p - v2/4096) * 6250 / v1
+		v1 = p9 * p * p / 2147483648
+		v2 = p * p8 / 32768
+		reading.pascals = p + (v1+v2+p7)/16
+	}
+
+	h1, h2, h3 := float64(calib[25]), s16(calibH[0:]), float64(calibH[2])
+	h4 := float64(int16(int8(calibH[3]))<<4 | int16(calibH[4]&0x0f))
+	h5 := float64(int16(int8(calibH[5]))<<4 | int16(calibH[4]>>4))
+	h6 := float64(int8(calibH[6]))
+	h := tFine - 76800
+	h = (adcH - (h4*64 + h5/16384*h)) * (h2 / 65536 * (1 + h6/67108864*h*(1+h3/67108864*h)))
+	h *= 1 - h1*h/524288
+	switch {
+	case h > 100:
+		h = 100
+	case h < 0:
+		h = 0
+	}
+	reading.humidity = h
+	return reading, nil
+}
@@ -0,0 +1,154 @@
+// fbinfogrid analog clock cell
+
+// Copyright ©2020 Steve Merrony
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"sync"
+	"time"
+)
+
+// drawAnalogClock starts the animation which keeps a clock face up to date; normally the second hand
+// ticks once a second but with smoothseconds it sweeps continuously
+func drawAnalogClock(ctx context.Context, wg *sync.WaitGroup, updateMu *sync.Mutex, cell CellT) {
+	animateClock(updateMu, cell, drawClockFace, nil)
+}
+
+// drawClockRing starts the animation which keeps a ring filling up over each minute (or hour) up to date,
+// with the time shown inside it if showtime is set
+func drawClockRing(ctx context.Context, wg *sync.WaitGroup, updateMu *sync.Mutex, cell CellT) {
+	var showTime func(*image.NRGBA, time.Time)
+	if cell.ShowTime {
+		showTime = func(img *image.NRGBA, t time.Time) {
+			writeText(cell, img, t.Format("15:04"))
+		}
+	}
+	animateClock(updateMu, cell, drawRing, showTime)
+}
+
+// animateClock keeps the cell showing the current time as drawn by face, updating it every second or,
+// with smoothseconds, many times a second; face is anti-aliased if the cell asks for it, and then
+// overlay (if any) is drawn on top
+func animateClock(updateMu *sync.Mutex, cell CellT, face func(*image.NRGBA, CellT, time.Time), overlay func(*image.NRGBA, time.Time)) {
+	if cell.animStop != nil {
+		return // already running
+	}
+	interval := 250 * time.Millisecond // checked a few times a second so that ticks are not late
+	if cell.SmoothSeconds {
+		interval = 50 * time.Millisecond
+	}
+	paint := func(int) {
+		now := time.Now()
+		if !cell.SmoothSeconds {
+			now = now.Truncate(time.Second)
+			if unchanged(cell, []byte(now.Format("15:04:05"))) {
+				return
+			}
+		}
+		if cell.AntiAlias {
+			smooth := antiAliased(cell.picture.Bounds().Dx(), cell.picture.Bounds().Dy(), func(img *image.NRGBA) {
+				face(img, cell, now)
+			})
+			draw.Draw(cell.picture, cell.picture.Bounds(), smooth, image.ZP, draw.Src)
+		} else {
+			face(cell.picture, cell, now)
+		}
+		if overlay != nil {
+			overlay(cell.picture, now)
+		}
+		updateMu.Lock()
+		render(cell.positionRect, cell.picture)
+		updateMu.Unlock()
+	}
+	paint(0)
+	startAnimation(cell, interval, paint)
+}
+
+// drawClockFace draws a clock showing the given time, with its hands positioned to the fraction of a second
+func drawClockFace(img *image.NRGBA, cell CellT, t time.Time) {
+	bounds := img.Bounds()
+	cx, cy := float64(bounds.Dx())/2, float64(bounds.Dy())/2
+	r := math.Min(cx, cy) * 0.95
+	draw.Draw(img, bounds, image.Black, image.ZP, draw.Src)
+
+	// the hour marks
+	for h := 0; h < 12; h++ {
+		angle := float64(h) * math.Pi / 6
+		inner := 0.85
+		if h%3 == 0 {
+			inner = 0.75
+		}
+		drawLine(img, cx+r*inner*math.Sin(angle), cy-r*inner*math.Cos(angle),
+			cx+r*math.Sin(angle), cy-r*math.Cos(angle), r/30, cell.fgColor)
+	}
+
+	secs := float64(t.Second()) + float64(t.Nanosecond())/1e9
+	mins := float64(t.Minute()) + secs/60
+	hours := float64(t.Hour()%12) + mins/60
+	hand := func(fraction, length, thickness float64, col color.Color) {
+		angle := fraction * 2 * math.Pi
+		drawLine(img, cx, cy, cx+r*length*math.Sin(angle), cy-r*length*math.Cos(angle), r*thickness, col)
+	}
+	hand(hours/12, 0.5, 0.07, cell.fgColor)
+	hand(mins/60, 0.8, 0.045, cell.fgColor)
+	hand(secs/60, 0.9, 0.015, namedColors["red"])
+	fillCircle(img, cx, cy, r*0.04, namedColors["red"])
+}
+
+// drawRing draws a ring, in the cell's colour over a dim track, filled clockwise from the top in
+// proportion to how far through the current minute (or hour, if the period is "hour") the time is
+func drawRing(img *image.NRGBA, cell CellT, t time.Time) {
+	bounds := img.Bounds()
+	cx, cy := float64(bounds.Dx())/2, float64(bounds.Dy())/2
+	outer := math.Min(cx, cy) * 0.95
+	inner := outer * 0.85
+	draw.Draw(img, bounds, image.Black, image.ZP, draw.Src)
+
+	secs := float64(t.Second()) + float64(t.Nanosecond())/1e9
+	filled := secs / 60
+	if cell.Period == "hour" {
+		filled = (float64(t.Minute())*60 + secs) / 3600
+	}
+	track := color.RGBA{cell.fgColor.R / 4, cell.fgColor.G / 4, cell.fgColor.B / 4, 255}
+	for y := int(cy - outer); y <= int(cy+outer); y++ {
+		for x := int(cx - outer); x <= int(cx+outer); x++ {
+			dx, dy := float64(x)+0.5-cx, float64(y)+0.5-cy
+			if dist := math.Hypot(dx, dy); dist < inner || dist > outer {
+				continue
+			}
+			// the angle clockwise from 12 o'clock, as a fraction of the whole way round
+			angle := math.Atan2(dx, -dy) / (2 * math.Pi)
+			if angle < 0 {
+				angle++
+			}
+			if angle < filled {
+				img.Set(x, y, cell.fgColor)
+			} else {
+				img.Set(x, y, track)
+			}
+		}
+	}
+}
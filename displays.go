@@ -0,0 +1,133 @@
+// fbinfogrid multiple framebuffer support
+
+// Copyright ©2020 Steve Merrony
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"syscall"
+)
+
+// displayEnv is set in the environment of the processes started to drive each
+// framebuffer, when the configuration uses more than one, to the device it is to use
+const displayEnv = "FBINFOGRID_DISPLAY"
+
+// defaultDisplayEnv is set alongside displayEnv to the device used by pages which do not name one,
+// as each process is given its own device via -fbdev
+const defaultDisplayEnv = "FBINFOGRID_DEFAULT_DISPLAY"
+
+// pageDevice returns the framebuffer device on which the page is shown
+func pageDevice(page PageT) string {
+	if page.FBDev != "" {
+		return page.FBDev
+	}
+	if dev := os.Getenv(defaultDisplayEnv); dev != "" {
+		return dev
+	}
+	return *fbdevFlag
+}
+
+// displayDevices returns the distinct framebuffer devices used by the configuration, in order of first use
+func displayDevices(config *ConfigT) (devices []string) {
+	seen := make(map[string]bool)
+	for _, page := range config.Pages {
+		if dev := pageDevice(page); !seen[dev] {
+			seen[dev] = true
+			devices = append(devices, dev)
+		}
+	}
+	return devices
+}
+
+// selectDisplayPages reduces the configuration to just the pages which are shown on the given device
+func selectDisplayPages(config *ConfigT, dev string) {
+	var pages []PageT
+	for _, page := range config.Pages {
+		if pageDevice(page) == dev {
+			pages = append(pages, page)
+		}
+	}
+	config.Pages = pages
+}
+
+// runDisplays drives each of several framebuffers from its own copy of the program, which runs
+// just the pages for that device, and stops them all (and exits) when any one of them stops.
+// The copies are given consecutive HTTP ports and their own state files.
+func runDisplays(devices []string) {
+	self, err := os.Executable()
+	if err != nil {
+		log.Fatalf("ERROR: Could not find the program to run for each display - %v\n", err)
+	}
+	var procs []*os.Process
+	exited := make(chan error, len(devices))
+	for i, dev := range devices {
+		args := append(append([]string{}, os.Args[1:]...), "-fbdev", dev)
+		if *httpFlag != 0 {
+			args = append(args, "-http", strconv.Itoa(*httpFlag+i))
+		}
+		if *stateFileFlag != "" {
+			args = append(args, "-state-file", *stateFileFlag+"."+dev)
+		}
+		cmd := exec.Command(self, args...)
+		cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+		if *configFlag == "-" {
+			cmd.Stdin = bytes.NewReader(stdinConfig) // the configuration we have already read
+		}
+		cmd.Env = append(os.Environ(), displayEnv+"="+dev, defaultDisplayEnv+"="+*fbdevFlag)
+		if err = cmd.Start(); err != nil {
+			log.Fatalf("ERROR: Could not start display %s - %v\n", dev, err)
+		}
+		log.Printf("INFO: Started display %s as process %d\n", dev, cmd.Process.Pid)
+		procs = append(procs, cmd.Process)
+		go func() { exited <- cmd.Wait() }()
+	}
+
+	// pass on any signals, eg. to pause, reload or stop, to every display
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM, syscall.SIGUSR1, syscall.SIGHUP)
+	go func() {
+		for sig := range sigs {
+			for _, proc := range procs {
+				proc.Signal(sig)
+			}
+		}
+	}()
+
+	err = <-exited
+	if err != nil {
+		log.Printf("WARNING: A display stopped unexpectedly - %v\n", err)
+	}
+	for _, proc := range procs {
+		proc.Signal(syscall.SIGTERM)
+	}
+	for range procs[1:] {
+		<-exited
+	}
+	if err != nil {
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
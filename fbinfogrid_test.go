@@ -0,0 +1,451 @@
+// fbinfogrid tests and benchmarks
+
+// Copyright ©2020 Steve Merrony
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/draw"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	framebuffer "github.com/gilphilbert/go-framebuffer"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/math/fixed"
+)
+
+// testCell returns a text cell of the given size, set up as if for drawing but without a page
+func testCell(tb testing.TB, w, h int) CellT {
+	var cell CellT
+	if err := json.Unmarshal([]byte(`{"celltype": "text", "fontpts": 40}`), &cell); err != nil {
+		tb.Fatal(err)
+	}
+	cell.font = loadFont(defaultFont)
+	cell.fgColor = namedColors["white"]
+	cell.positionRect = image.Rect(0, 0, w, h)
+	cell.picture = image.NewNRGBA(cell.positionRect)
+	return cell
+}
+
+// BenchmarkPictureNew allocates a cell-sized picture afresh each time, as was done before pooling
+func BenchmarkPictureNew(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_ = image.NewNRGBA(image.Rect(0, 0, 640, 360))
+	}
+}
+
+// BenchmarkPicturePooled takes a cell-sized picture from its pool and returns it, as happens each
+// time a page is shown and left
+func BenchmarkPicturePooled(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		putPicture(getPicture(640, 360))
+	}
+}
+
+// BenchmarkWriteText draws a short string into a cell's picture, as a time or text cell does on each refresh
+func BenchmarkWriteText(b *testing.B) {
+	cell := testCell(b, 640, 360)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		writeText(cell, cell.picture, "15:04")
+	}
+}
+
+// TestDrawImageFormats checks that BMP and TIFF images, as well as the standard formats, can be shown
+func TestDrawImageFormats(t *testing.T) {
+	var updateMu sync.Mutex
+	fbcopy = image.NewNRGBA(image.Rect(0, 0, 8, 8)) // the HTTP copy shows what was rendered
+	defer func() { fbcopy = nil }()
+	for _, file := range []string{"testdata/red.png", "testdata/red.jpg", "testdata/red.gif", "testdata/red.bmp", "testdata/red.tiff"} {
+		cell := testCell(t, 8, 8)
+		f, err := os.Open(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = drawImage(f, cell, &updateMu)
+		f.Close()
+		if err != nil {
+			t.Errorf("%s: could not be drawn - %v", file, err)
+			continue
+		}
+		// JPEG is lossy, so allow a little leeway
+		if got := fbcopy.NRGBAAt(4, 4); got.R < 250 || got.G > 5 || got.B > 5 || got.A != 255 {
+			t.Errorf("%s: drawn as %v, want red", file, got)
+		}
+	}
+}
+
+// TestReloadBadColor checks that reloading a configuration with an invalid cell or gridlines
+// colour keeps the running pages rather than stopping the program
+func TestReloadBadColor(t *testing.T) {
+	fb = &framebuffer.Framebuffer{Xres: 1920, Yres: 1080}
+	path := filepath.Join(t.TempDir(), "config.json")
+	savedPath := *configFlag
+	*configFlag = path
+	defer func() { *configFlag = savedPath }()
+	writeConfig := func(fgColor, gridColor string) {
+		conf := `{"pages": [{"name": "one", "rows": 1, "cols": 1, "gridlines": {"color": "` + gridColor + `"}, "cells": [
+			{"celltype": "text", "row": 1, "col": 1, "text": "Hello", "fgcolor": "` + fgColor + `"}]}]}`
+		if err := ioutil.WriteFile(path, []byte(conf), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	writeConfig("green", "grey")
+	config = loadConfig(path)
+	hashPages(config)
+	if err := validateConfig(config); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkCells(config); err != nil {
+		t.Fatal(err)
+	}
+	running := config.Pages[0]
+
+	for _, bad := range [][2]string{{"purpl", "grey"}, {"green", "nope"}} {
+		writeConfig(bad[0], bad[1])
+		if reloadPages() {
+			t.Errorf("%v: the current page was changed by an invalid configuration", bad)
+		}
+		if len(config.Pages) != 1 || config.Pages[0] != running {
+			t.Errorf("%v: the running pages were not kept", bad)
+		}
+	}
+}
+
+// TestReloadIdenticalPages checks that pages with the same configuration each keep their own
+// running page when the configuration is reloaded
+func TestReloadIdenticalPages(t *testing.T) {
+	fb = &framebuffer.Framebuffer{Xres: 1920, Yres: 1080}
+	path := filepath.Join(t.TempDir(), "config.json")
+	savedPath := *configFlag
+	*configFlag = path
+	defer func() { *configFlag = savedPath }()
+	page := `{"rows": 1, "cols": 1, "cells": [{"celltype": "text", "row": 1, "col": 1, "text": "Hello"}]}`
+	if err := ioutil.WriteFile(path, []byte(`{"pages": [`+page+`, `+page+`]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	config = loadConfig(path)
+	hashPages(config)
+	if err := validateConfig(config); err != nil {
+		t.Fatal(err)
+	}
+	running := append([]PageT(nil), config.Pages...)
+
+	if reloadPages() {
+		t.Error("the current page was changed by reloading the same configuration")
+	}
+	for pIx, page := range config.Pages {
+		if page != running[pIx] {
+			t.Errorf("page %d was not kept in place", pIx)
+		}
+	}
+}
+
+// TestParseMarkup checks how text with inline colour markup is split into runs
+func TestParseMarkup(t *testing.T) {
+	def := image.NewUniform(namedColors["white"])
+	green, red := namedColors["green"], namedColors["red"]
+	type runT struct {
+		text string
+		col  color.Color
+	}
+	for _, test := range []struct {
+		text string
+		want []runT
+	}{
+		{"", nil},
+		{"plain", []runT{{"plain", def.C}}},
+		{"CPU: [green]42%[/]", []runT{{"CPU: ", def.C}, {"42%", green}}},
+		{"[red]hot[/] and [#00ff00]ok", []runT{{"hot", red}, {" and ", def.C}, {"ok", color.RGBA{0, 255, 0, 255}}}},
+		{"[green][red]x", []runT{{"x", red}}},
+		{"array[0] [unknown]", []runT{{"array[0] [unknown]", def.C}}},
+		{"[green]unclosed [red", []runT{{"unclosed [red", green}}},
+		{"ends [/]", []runT{{"ends ", def.C}}},
+	} {
+		var got []runT
+		for _, run := range parseMarkup(test.text, def) {
+			got = append(got, runT{run.text, run.src.(*image.Uniform).C})
+		}
+		if len(got) != len(test.want) {
+			t.Errorf("%q: got runs %v, want %v", test.text, got, test.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != test.want[i] {
+				t.Errorf("%q: got runs %v, want %v", test.text, got, test.want)
+				break
+			}
+		}
+	}
+}
+
+// TestWriteLinesMarkup checks that wrapped text is drawn in its markup colours, not with the markup
+// shown, and that a colour carries on over a line break
+func TestWriteLinesMarkup(t *testing.T) {
+	cell := testCell(t, 200, 200)
+	draw.Draw(cell.picture, cell.picture.Bounds(), image.Black, image.ZP, draw.Src)
+	writeLines(cell, cell.picture, []string{"[red]HHH", "HHH[/]"})
+	counts := make(map[color.NRGBA]int)
+	for y := 0; y < 200; y++ {
+		for x := 0; x < 200; x++ {
+			counts[cell.picture.NRGBAAt(x, y)]++
+		}
+	}
+	if counts[color.NRGBA{255, 0, 0, 255}] == 0 {
+		t.Error("no text was drawn in red")
+	}
+	if n := counts[color.NRGBA{255, 255, 255, 255}]; n != 0 {
+		t.Errorf("%d pixels were drawn in the cell's colour, want none", n)
+	}
+}
+
+// inkWidth returns the distance between the first and last columns of the image with anything
+// other than black in them
+func inkWidth(img *image.NRGBA) int {
+	first, last := -1, -1
+	for x := img.Bounds().Min.X; x < img.Bounds().Max.X; x++ {
+		for y := img.Bounds().Min.Y; y < img.Bounds().Max.Y; y++ {
+			if img.NRGBAAt(x, y) != (color.NRGBA{0, 0, 0, 255}) {
+				if first == -1 {
+					first = x
+				}
+				last = x
+				break
+			}
+		}
+	}
+	return last - first
+}
+
+// TestWriteLinesLetterSpacing checks that letterspacing widens multi-line text as it does a single line
+func TestWriteLinesLetterSpacing(t *testing.T) {
+	widths := make(map[float64]int)
+	for _, spacing := range []float64{0, 20} {
+		cell := testCell(t, 400, 200)
+		cell.LetterSpacing = spacing
+		draw.Draw(cell.picture, cell.picture.Bounds(), image.Black, image.ZP, draw.Src)
+		writeLines(cell, cell.picture, []string{"HHH", "HHH"})
+		widths[spacing] = inkWidth(cell.picture)
+	}
+	if widths[20] <= widths[0] {
+		t.Errorf("text with letterspacing is %d pixels wide, without it %d", widths[20], widths[0])
+	}
+}
+
+// TestWriteLinesAutoContrast checks that autocontrast picks a colour for multi-line text which
+// stands out against what is behind it
+func TestWriteLinesAutoContrast(t *testing.T) {
+	cell := testCell(t, 200, 200)
+	cell.AutoContrast = true
+	draw.Draw(cell.picture, cell.picture.Bounds(), image.White, image.ZP, draw.Src)
+	writeLines(cell, cell.picture, []string{"HHH", "HHH"})
+	black := 0
+	for y := 0; y < 200; y++ {
+		for x := 0; x < 200; x++ {
+			if cell.picture.NRGBAAt(x, y) == (color.NRGBA{0, 0, 0, 255}) {
+				black++
+			}
+		}
+	}
+	if black == 0 {
+		t.Error("text over white was not drawn in black")
+	}
+}
+
+// TestShowImageFitCentred checks that an image fitted to a wider cell is centred in it, whether
+// or not it is to be crossfaded
+func TestShowImageFitCentred(t *testing.T) {
+	var updateMu sync.Mutex
+	fbcopy = image.NewNRGBA(image.Rect(0, 0, 8, 4))
+	defer func() { fbcopy = nil }()
+	red := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+	draw.Draw(red, red.Bounds(), image.NewUniform(color.NRGBA{255, 0, 0, 255}), image.ZP, draw.Src)
+	for _, crossfadeMs := range []int{0, 500} {
+		cell := testCell(t, 8, 4)
+		cell.Scaling = "fit"
+		cell.CrossfadeMs = crossfadeMs
+		showImage(red, cell, &updateMu)
+		if got := fbcopy.NRGBAAt(0, 2); got != (color.NRGBA{0, 0, 0, 255}) {
+			t.Errorf("crossfadems %d: left border drawn as %v, want black", crossfadeMs, got)
+		}
+		if got := fbcopy.NRGBAAt(4, 2); got != (color.NRGBA{255, 0, 0, 255}) {
+			t.Errorf("crossfadems %d: centre drawn as %v, want red", crossfadeMs, got)
+		}
+	}
+}
+
+// TestCheckConfigSource checks that only configurations fetched with plain http which run commands are refused
+func TestCheckConfigSource(t *testing.T) {
+	for _, test := range []struct {
+		source, conf string
+		refused      bool
+	}{
+		{"http://server/config.json", `{"pages": [{"cells": [{"celltype": "text", "text": "Hello"}]}]}`, false},
+		{"http://server/config.json", `{"pages": [{"cells": [{"celltype": "plugin", "source": "uptime"}]}]}`, true},
+		{"http://server/config.json", `{"pages": [{"cells": [{"celltype": "text", "source": "cmd:date"}]}]}`, true},
+		{"http://server/config.json", `{"pages": [{"cells": [{"celltype": "kvlist", "sources": ["/tmp/a", "cmd:date"]}]}]}`, true},
+		{"http://server/config.json", `{"pages": [{"cells": [{"celltype": "metric", "sourcetype": "command", "source": "date"}]}]}`, true},
+		{"http://server/config.json", `{"templates": {"base": [{"celltype": "plugin", "source": "uptime"}]}, "pages": []}`, true},
+		{"https://server/config.json", `{"pages": [{"cells": [{"celltype": "plugin", "source": "uptime"}]}]}`, false},
+		{"config.json", `{"pages": [{"cells": [{"celltype": "plugin", "source": "uptime"}]}]}`, false},
+	} {
+		var conf ConfigT
+		if err := json.Unmarshal([]byte(test.conf), &conf); err != nil {
+			t.Fatal(err)
+		}
+		if err := checkConfigSource(test.source, &conf); (err != nil) != test.refused {
+			t.Errorf("%s %s: got error %v, want refused %v", test.source, test.conf, err, test.refused)
+		}
+	}
+}
+
+// TestHandlersJPGFormat checks that the pages showing the framebuffer copy accept "jpg" as well as "jpeg"
+func TestHandlersJPGFormat(t *testing.T) {
+	for _, handler := range []http.HandlerFunc{fbcopyHandler, viewHandler} {
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest(http.MethodGet, "/?format=jpg", nil))
+		if body := rec.Body.String(); !strings.Contains(body, "format=jpg") {
+			t.Errorf("page does not ask for a JPEG: %s", body)
+		}
+	}
+}
+
+// TestFetchCancelledNotRecorded checks that fetches cut short by their context do not count
+// towards tripping the host's circuit breaker
+func TestFetchCancelledNotRecorded(t *testing.T) {
+	release := make(chan bool)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		select {
+		case <-release:
+		case <-req.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+	fetchSem = make(chan struct{}, 1)
+	for i := 0; i < breakerThreshold; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		_, err := fetchRequest(ctx, http.MethodGet, server.URL, nil)
+		cancel()
+		if err == nil {
+			t.Fatal("fetch succeeded despite timing out")
+		}
+	}
+	breakersMu.Lock()
+	defer breakersMu.Unlock()
+	if b, found := breakers[urlHost(server.URL)]; found {
+		t.Errorf("timed out fetches were recorded as %d failures", b.failures)
+	}
+}
+
+// TestMergeHeadlines checks that refetched headlines keep their order, without duplicates, and
+// that the ticker carries on from the same headline
+func TestMergeHeadlines(t *testing.T) {
+	for _, test := range []struct {
+		old, latest []string
+		next        int
+		want        []string
+		wantNext    int
+	}{
+		{nil, []string{"a", "b", "a", "c"}, 0, []string{"a", "b", "c"}, 0},
+		{[]string{"a", "b", "c"}, []string{"b", "c", "d"}, 2, []string{"b", "c", "d"}, 1},
+		{[]string{"a", "b", "c"}, []string{"c", "a"}, 1, []string{"a", "c"}, 1},
+		{[]string{"a", "b"}, []string{"b", "a", "b", "e", "e"}, 1, []string{"a", "b", "e"}, 1},
+		{[]string{"a", "b"}, []string{"x"}, 2, []string{"x"}, 0},
+		{[]string{"a"}, nil, 1, nil, 0},
+	} {
+		got, gotNext := mergeHeadlines(test.old, test.next, test.latest)
+		if strings.Join(got, "|") != strings.Join(test.want, "|") || gotNext != test.wantNext {
+			t.Errorf("merging %q (next %d) with %q: got %q (next %d), want %q (next %d)",
+				test.old, test.next, test.latest, got, gotNext, test.want, test.wantNext)
+		}
+	}
+}
+
+// TestTableColumnWidths checks that columns take their widest field, and are narrowed in
+// proportion when the table is wider than the cell
+func TestTableColumnWidths(t *testing.T) {
+	cell := testCell(t, 100, 100)
+	textMu.Lock()
+	defer textMu.Unlock()
+	d := &font.Drawer{Face: fontFace(cellFace(cell))}
+	pad := d.MeasureString("  ")
+	wide := strings.Repeat("W", 200) // thousands of pixels, so the 26.6 arithmetic would overflow 32 bits
+	for _, test := range []struct {
+		records [][]string
+		avail   fixed.Int26_6
+	}{
+		{[][]string{{"a", "bbb"}, {"cc", "d"}}, fixed.I(10000)},
+		{[][]string{{"a"}, {"bb", "c", "dddd"}}, fixed.I(10000)},
+		{[][]string{{"name", "value"}, {"temperature", "21.5"}}, fixed.I(100)},
+		{[][]string{{wide, wide}, {"x", wide}}, fixed.I(3000)},
+	} {
+		var natural []fixed.Int26_6
+		total := fixed.I(0)
+		for _, record := range test.records {
+			for colIx, field := range record {
+				if colIx == len(natural) {
+					natural = append(natural, 0)
+				}
+				if w := d.MeasureString(field) + pad; w > natural[colIx] {
+					natural[colIx] = w
+				}
+			}
+		}
+		for _, w := range natural {
+			total += w
+		}
+		got := tableColumnWidths(d, test.records, pad, test.avail)
+		if len(got) != len(natural) {
+			t.Errorf("%q: got %d columns, want %d", test.records, len(got), len(natural))
+			continue
+		}
+		sum := fixed.I(0)
+		for colIx, w := range got {
+			sum += w
+			want := natural[colIx]
+			if total > test.avail {
+				want = fixed.Int26_6(float64(natural[colIx]) * float64(test.avail) / float64(total))
+			}
+			if w < want-1 || w > want+1 {
+				t.Errorf("%q: column %d is %v wide, want %v", test.records, colIx, w, want)
+			}
+		}
+		if total > test.avail && sum > test.avail {
+			t.Errorf("%q: columns total %v, wider than the %v available", test.records, sum, test.avail)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+// fbinfogrid ICMP echo (ping) support
+
+// Copyright ©2020 Steve Merrony
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+)
+
+const protocolICMP = 1
+
+// pingICMP sends a single ICMP echo request to host and reports whether a reply was received
+// within the timeout.  An error is returned if the ping could not be attempted at all, typically
+// because the program does not have CAP_NET_RAW (or root) and unprivileged pings are not allowed.
+func pingICMP(host string, timeout time.Duration) (bool, error) {
+	dst, err := net.ResolveIPAddr("ip4", host)
+	if err != nil {
+		return false, nil // an unresolvable host is simply not alive
+	}
+	// try a raw socket first, then the unprivileged datagram variant
+	network := "ip4:icmp"
+	conn, err := icmp.ListenPacket(network, "0.0.0.0")
+	if err != nil {
+		network = "udp4"
+		conn, err = icmp.ListenPacket(network, "0.0.0.0")
+		if err != nil {
+			return false, fmt.Errorf("ICMP not permitted - %v", err)
+		}
+	}
+	defer conn.Close()
+
+	msg := icmp.Message{
+		Type: ipv4.ICMPTypeEcho,
+		Body: &icmp.Echo{ID: os.Getpid() & 0xffff, Seq: 1, Data: []byte("fbinfogrid")},
+	}
+	wb, err := msg.Marshal(nil)
+	if err != nil {
+		return false, err
+	}
+	var target net.Addr = dst
+	if network == "udp4" {
+		target = &net.UDPAddr{IP: dst.IP}
+	}
+	if _, err = conn.WriteTo(wb, target); err != nil {
+		return false, nil
+	}
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	rb := make([]byte, 1500)
+	for {
+		n, peer, err := conn.ReadFrom(rb)
+		if err != nil {
+			return false, nil // timed out
+		}
+		reply, err := icmp.ParseMessage(protocolICMP, rb[:n])
+		if err != nil || reply.Type != ipv4.ICMPTypeEchoReply {
+			continue
+		}
+		// other processes' replies may arrive on a raw socket
+		switch p := peer.(type) {
+		case *net.IPAddr:
+			if p.IP.Equal(dst.IP) {
+				return true, nil
+			}
+		case *net.UDPAddr:
+			if p.IP.Equal(dst.IP) {
+				return true, nil
+			}
+		}
+	}
+}
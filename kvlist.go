@@ -0,0 +1,77 @@
+// fbinfogrid key/value list cell
+
+// Copyright ©2020 Steve Merrony
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"image"
+	"image/draw"
+	"strings"
+	"sync"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/math/fixed"
+)
+
+// drawKVList displays a list of labels, each with a value read from the corresponding source
+// right-aligned against it, the last good value is kept for any source which cannot be read
+func drawKVList(ctx context.Context, wg *sync.WaitGroup, updateMu *sync.Mutex, cell CellT) {
+	for i, src := range cell.Sources {
+		val, err := readValue(ctx, cell, src)
+		if err != nil {
+			cellWarning(cell, "Could not get value from %s due to %s", src, err)
+			continue
+		}
+		cell.lastValues[i] = val
+	}
+	if unchanged(cell, []byte(strings.Join(cell.lastValues, "\x00"))) {
+		return
+	}
+	updateMu.Lock()
+	defer updateMu.Unlock()
+	textMu.Lock()
+	defer textMu.Unlock()
+	d := &font.Drawer{
+		Dst:  cell.picture,
+		Src:  image.NewUniform(cell.fgColor),
+		Face: fontFace(cellFace(cell)),
+	}
+	metrics := d.Face.Metrics()
+	width := fixed.I(cell.picture.Bounds().Dx())
+	gap := d.MeasureString("  ")
+
+	draw.Draw(cell.picture, cell.picture.Bounds(), image.Black, image.ZP, draw.Src)
+	y := metrics.Ascent
+	for i, label := range cell.Labels {
+		if y+metrics.Descent > fixed.I(cell.picture.Bounds().Dy()) {
+			break // no room for any more rows
+		}
+		val := truncateToWidth(d, cell.lastValues[i], width)
+		valWidth := d.MeasureString(val)
+		d.Dot = fixed.Point26_6{X: 0, Y: y}
+		d.DrawString(truncateToWidth(d, label, width-valWidth-gap))
+		d.Dot = fixed.Point26_6{X: width - valWidth, Y: y}
+		d.DrawString(val)
+		y += lineSpacing(cell, metrics.Height)
+	}
+	render(cell.positionRect, cell.picture)
+}
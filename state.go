@@ -0,0 +1,124 @@
+// fbinfogrid persistent cell state
+
+// Copyright ©2020 Steve Merrony
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// cellStateT is what is remembered about a cell between runs
+type cellStateT struct {
+	Count     *int   `json:"count,omitempty"`
+	Text      string `json:"text,omitempty"`
+	Condition string `json:"condition,omitempty"`
+}
+
+// loadState restores the values of cells (with ids) saved in the state file by a previous run
+func loadState(path string) {
+	stateJSON, err := ioutil.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("WARNING: Could not read state file %s due to %s", path, err)
+		}
+		return
+	}
+	states := make(map[string]cellStateT)
+	if err = json.Unmarshal(stateJSON, &states); err != nil {
+		log.Printf("WARNING: Could not parse state file %s due to %s", path, err)
+		return
+	}
+	statusMu.Lock()
+	defer statusMu.Unlock()
+	for id, state := range states {
+		cell := findCell(id)
+		if cell == nil {
+			continue // the cell has been removed from the configuration
+		}
+		if state.Count != nil {
+			cell.count = *state.Count
+			cell.countKnown = true
+		}
+		cell.lastText = state.Text
+		cell.condition = state.Condition
+	}
+	log.Printf("INFO: Restored the state of %d cells from %s\n", len(states), path)
+}
+
+// setLastText records the latest value of a cell, under statusMu as saveState may read it at any time
+func setLastText(cell CellT, text string) {
+	statusMu.Lock()
+	cell.lastText = text
+	statusMu.Unlock()
+}
+
+// saveState writes the current values of all cells with ids to the state file,
+// via a temporary file so that a crash part way through cannot leave it corrupted
+func saveState(path string) {
+	states := make(map[string]cellStateT)
+	statusMu.Lock()
+	for _, page := range config.Pages {
+		for id, cell := range page.cellsByID {
+			state := cellStateT{Text: cell.lastText, Condition: cell.condition}
+			if cell.countKnown {
+				count := cell.count
+				state.Count = &count
+			}
+			if state.Count != nil || state.Text != "" || state.Condition != "" {
+				states[id] = state
+			}
+		}
+	}
+	statusMu.Unlock()
+	stateJSON, err := json.MarshalIndent(states, "", "  ")
+	if err != nil {
+		log.Printf("WARNING: Could not encode state due to %s", err)
+		return
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".fbinfogrid-state")
+	if err != nil {
+		log.Printf("WARNING: Could not save state due to %s", err)
+		return
+	}
+	_, err = tmp.Write(stateJSON)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		log.Printf("WARNING: Could not save state to %s due to %s", path, err)
+	}
+}
+
+// saveStatePeriodically saves the state file every interval, forever
+func saveStatePeriodically(path string, interval time.Duration) {
+	for range time.Tick(interval) {
+		saveState(path)
+	}
+}
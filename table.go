@@ -0,0 +1,126 @@
+// fbinfogrid CSV table cell
+
+// Copyright ©2020 Steve Merrony
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"image"
+	"image/draw"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/math/fixed"
+)
+
+// drawTable displays CSV data from a file, URL or command as a table with auto-sized columns
+func drawTable(ctx context.Context, wg *sync.WaitGroup, updateMu *sync.Mutex, cell CellT) {
+	csvStr, err := readSource(ctx, cell.Source)
+	if err != nil {
+		cellWarning(cell, "Could not read table from %s due to %s", cell.Source, err)
+		return
+	}
+	if unchanged(cell, []byte(csvStr)) {
+		return
+	}
+	r := csv.NewReader(strings.NewReader(csvStr))
+	r.FieldsPerRecord = -1 // allow ragged rows
+	records, err := r.ReadAll()
+	if err != nil {
+		cell.drawnValid = false // nothing was drawn, so the same CSV must not count as unchanged
+		cellWarning(cell, "Could not parse CSV from %s due to %s", cell.Source, err)
+		return
+	}
+	updateMu.Lock()
+	defer updateMu.Unlock()
+	textMu.Lock()
+	defer textMu.Unlock()
+	d := &font.Drawer{
+		Dst:  cell.picture,
+		Src:  image.NewUniform(cell.fgColor),
+		Face: fontFace(cellFace(cell)),
+	}
+	colPad := d.MeasureString("  ")
+	colWidths := tableColumnWidths(d, records, colPad, fixed.I(cell.picture.Bounds().Dx()))
+	metrics := d.Face.Metrics()
+
+	draw.Draw(cell.picture, cell.picture.Bounds(), image.Black, image.ZP, draw.Src)
+	y := metrics.Ascent
+	for rowIx, record := range records {
+		if y+metrics.Descent > fixed.I(cell.picture.Bounds().Dy()) {
+			break // no room for any more rows
+		}
+		d.Src = image.NewUniform(cell.fgColor)
+		if rowIx == 0 && cell.Header {
+			d.Src = image.NewUniform(cell.headerColor)
+		}
+		x := fixed.I(0)
+		for colIx, field := range record {
+			field = truncateToWidth(d, field, colWidths[colIx]-colPad)
+			d.Dot = fixed.Point26_6{X: x, Y: y}
+			// right-align numbers so that they line up
+			if _, err := strconv.ParseFloat(field, 64); err == nil {
+				d.Dot.X = x + colWidths[colIx] - colPad - d.MeasureString(field)
+			}
+			d.DrawString(field)
+			x += colWidths[colIx]
+		}
+		y += metrics.Height
+	}
+	render(cell.positionRect, cell.picture)
+}
+
+// tableColumnWidths returns the natural width of each column (including padding),
+// proportionally reduced if necessary so that the table fits the available width
+func tableColumnWidths(d *font.Drawer, records [][]string, colPad, avail fixed.Int26_6) (widths []fixed.Int26_6) {
+	total := fixed.I(0)
+	for _, record := range records {
+		for colIx, field := range record {
+			if colIx == len(widths) {
+				widths = append(widths, colPad)
+				total += colPad
+			}
+			if w := d.MeasureString(field) + colPad; w > widths[colIx] {
+				total += w - widths[colIx]
+				widths[colIx] = w
+			}
+		}
+	}
+	if total > avail {
+		for colIx := range widths {
+			// in 64 bits as the product of two 26.6 widths overflows for a wide cell
+			widths[colIx] = fixed.Int26_6(int64(widths[colIx]) * int64(avail) / int64(total))
+		}
+	}
+	return widths
+}
+
+// truncateToWidth shortens text until it fits within the given width
+func truncateToWidth(d *font.Drawer, text string, width fixed.Int26_6) string {
+	runes := []rune(text)
+	for len(runes) > 0 && d.MeasureString(string(runes)) > width {
+		runes = runes[:len(runes)-1]
+	}
+	return string(runes)
+}
@@ -0,0 +1,188 @@
+// fbinfogrid news ticker cell
+
+// Copyright ©2020 Steve Merrony
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"image"
+	"image/draw"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultTickerSeparator = "  |  "
+
+// feedT holds the headlines of an RSS (0.9x, 1.0 or 2.0) or Atom feed
+type feedT struct {
+	RSSItems []struct {
+		Title string `xml:"title"`
+	} `xml:"channel>item"`
+	RDFItems []struct {
+		Title string `xml:"title"`
+	} `xml:"item"`
+	Entries []struct {
+		Title string `xml:"title"`
+	} `xml:"entry"`
+}
+
+// drawTicker fetches the headlines of the feed at the cell's source and keeps them scrolling
+// from right to left across the cell, one after another; when the feed is fetched again any
+// new headlines are added to the end of those still in it, so the ticker carries on undisturbed
+func drawTicker(ctx context.Context, wg *sync.WaitGroup, updateMu *sync.Mutex, cell CellT) {
+	headlines, err := fetchHeadlines(ctx, cell.Source)
+	if err != nil {
+		cellWarning(cell, "Could not get headlines from %s due to %s", cell.Source, err)
+	} else {
+		updateMu.Lock()
+		cell.headlines, cell.nextHeadline = mergeHeadlines(cell.headlines, cell.nextHeadline, headlines)
+		updateMu.Unlock()
+	}
+	if cell.animStop != nil {
+		return // already scrolling
+	}
+	const frameTime = 40 * time.Millisecond
+	bounds := cell.picture.Bounds()
+	speed := cell.ScrollPxPerSec
+	if speed <= 0 {
+		speed = defaultScrollSpeed * 3
+	}
+	type segmentT struct {
+		img *image.NRGBA
+		x   int
+	}
+	var (
+		segments []segmentT
+		moved    float64
+	)
+	window := image.NewNRGBA(bounds)
+	startAnimation(cell, frameTime, func(frame int) {
+		updateMu.Lock()
+		defer updateMu.Unlock()
+		moved += speed * frameTime.Seconds()
+		shift := int(moved)
+		moved -= float64(shift)
+		for len(segments) > 0 && segments[0].x+segments[0].img.Bounds().Dx()-shift <= 0 {
+			segments = segments[1:]
+		}
+		tail := bounds.Dx()
+		for i := range segments {
+			segments[i].x -= shift
+			tail = segments[i].x + segments[i].img.Bounds().Dx()
+		}
+		// bring on the next headline as soon as the end of the last one is in view
+		for tail < bounds.Dx() || len(segments) == 0 {
+			strip := headlineStrip(cell)
+			if strip == nil {
+				break // nothing to show yet
+			}
+			segments = append(segments, segmentT{img: strip, x: tail})
+			tail += strip.Bounds().Dx()
+		}
+		draw.Draw(window, bounds, image.Black, image.ZP, draw.Src)
+		for _, seg := range segments {
+			at := image.Rect(bounds.Min.X+seg.x, bounds.Min.Y, bounds.Min.X+seg.x+seg.img.Bounds().Dx(), bounds.Max.Y)
+			draw.Draw(window, at, seg.img, image.ZP, draw.Src)
+		}
+		render(cell.positionRect, window)
+	})
+}
+
+// headlineStrip returns the cell's next headline, followed by the separator, drawn ready to be
+// scrolled across the cell, or nil if there are no headlines; it must be called with the update mutex held
+func headlineStrip(cell CellT) *image.NRGBA {
+	if len(cell.headlines) == 0 {
+		return nil
+	}
+	if cell.nextHeadline >= len(cell.headlines) {
+		cell.nextHeadline = 0
+	}
+	text := cell.headlines[cell.nextHeadline] + cell.Text
+	cell.nextHeadline++
+	strip := image.NewNRGBA(image.Rect(0, 0, textWidth(cell, text)+1, cell.picture.Bounds().Dy()))
+	draw.Draw(strip, strip.Bounds(), image.Black, image.ZP, draw.Src)
+	writeText(cell, strip, text)
+	return strip
+}
+
+// mergeHeadlines returns the headlines which are still in the latest fetch of a feed, in their
+// original order and followed by any new ones, together with the new position of the next to be shown
+func mergeHeadlines(old []string, next int, latest []string) ([]string, int) {
+	inLatest := make(map[string]bool, len(latest))
+	for _, h := range latest {
+		inLatest[h] = true
+	}
+	var merged []string
+	seen := make(map[string]bool, len(latest))
+	newNext := 0
+	for i, h := range old {
+		if inLatest[h] {
+			merged = append(merged, h)
+			seen[h] = true
+			if i < next {
+				newNext++
+			}
+		}
+	}
+	for _, h := range latest {
+		if !seen[h] {
+			merged = append(merged, h)
+			seen[h] = true
+		}
+	}
+	return merged, newNext
+}
+
+// fetchHeadlines returns the titles of the items in the RSS or Atom feed at the URL
+func fetchHeadlines(ctx context.Context, url string) ([]string, error) {
+	body, err := fetchURL(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	var feed feedT
+	if err = xml.Unmarshal(body, &feed); err != nil {
+		return nil, err
+	}
+	var titles []string
+	add := func(title string) {
+		// some feeds escape their titles twice, and most are full of stray whitespace
+		if title = strings.Join(strings.Fields(html.UnescapeString(title)), " "); title != "" {
+			titles = append(titles, title)
+		}
+	}
+	for _, item := range feed.RSSItems {
+		add(item.Title)
+	}
+	for _, item := range feed.RDFItems {
+		add(item.Title)
+	}
+	for _, entry := range feed.Entries {
+		add(entry.Title)
+	}
+	if len(titles) == 0 {
+		return nil, fmt.Errorf("no headlines found in feed")
+	}
+	return titles, nil
+}
@@ -0,0 +1,306 @@
+// fbinfogrid weather cell
+
+// Copyright ©2020 Steve Merrony
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/disintegration/imaging"
+)
+
+// openMeteoURL is the free, keyless weather service used by the weather cell
+const openMeteoURL = "https://api.open-meteo.com/v1/forecast?latitude=%f&longitude=%f&current_weather=true&temperature_unit=%s"
+
+// openMeteoDailyURL is the same service's daily forecast, used by the forecast cell
+const openMeteoDailyURL = "https://api.open-meteo.com/v1/forecast?latitude=%f&longitude=%f&daily=weathercode,temperature_2m_max,temperature_2m_min&timezone=auto&forecast_days=%d&temperature_unit=%s"
+
+// forecastDayT is one day of a weather forecast
+type forecastDayT struct {
+	day       string // eg. "Mon"
+	condition string
+	high, low float64
+}
+
+// weatherCondition maps a WMO weather interpretation code (as used by Open-Meteo)
+// to the name of the corresponding icon
+func weatherCondition(code int) string {
+	switch {
+	case code == 0:
+		return "clear"
+	case code <= 2:
+		return "partlycloudy"
+	case code == 3:
+		return "cloudy"
+	case code == 45 || code == 48:
+		return "fog"
+	case code >= 71 && code <= 77, code == 85, code == 86:
+		return "snow"
+	case code >= 95:
+		return "thunder"
+	default: // drizzle, rain and showers
+		return "rain"
+	}
+}
+
+// drawWeather displays an icon for the current weather conditions along with the temperature
+func drawWeather(ctx context.Context, wg *sync.WaitGroup, updateMu *sync.Mutex, cell CellT) {
+	showLoading(cell, updateMu)
+	temp, code, err := fetchCurrentWeather(ctx, cell.Source)
+	if err != nil {
+		cellWarning(cell, "Could not get weather from %s due to %s", cell.Source, err)
+	} else {
+		statusMu.Lock()
+		cell.lastText = fmt.Sprintf("%.0f°", temp)
+		cell.condition = weatherCondition(code)
+		statusMu.Unlock()
+	}
+	if cell.condition == "" {
+		return // nothing to show yet
+	}
+	stopAnimation(cell) // the loading placeholder, if any
+	if unchanged(cell, []byte(cell.condition+cell.lastText)) {
+		return
+	}
+	bounds := cell.picture.Bounds()
+	iconSize := bounds.Dy()
+	if iconSize > bounds.Dx()/2 {
+		iconSize = bounds.Dx() / 2
+	}
+	icon := weatherIcon(cell.IconDir, cell.condition, iconSize, cell.AntiAlias)
+	updateMu.Lock()
+	draw.Draw(cell.picture, bounds, image.Black, image.ZP, draw.Src)
+	iconTop := (bounds.Dy() - iconSize) / 2
+	draw.Draw(cell.picture, image.Rect(0, iconTop, iconSize, iconTop+iconSize), icon, image.ZP, draw.Over)
+	writeText(cell, cell.picture.SubImage(image.Rect(iconSize, 0, bounds.Dx(), bounds.Dy())).(draw.Image), cell.lastText)
+	render(cell.positionRect, cell.picture)
+	updateMu.Unlock()
+}
+
+// fetchCurrentWeather returns the current temperature and WMO weather code from an Open-Meteo URL
+func fetchCurrentWeather(ctx context.Context, url string) (temp float64, code int, err error) {
+	body, err := fetchURL(ctx, url)
+	if err != nil {
+		return 0, 0, err
+	}
+	var data interface{}
+	if err = json.Unmarshal(body, &data); err != nil {
+		return 0, 0, err
+	}
+	tempVal, err := jsonValue(data, "current_weather.temperature")
+	if err != nil {
+		return 0, 0, err
+	}
+	codeVal, err := jsonValue(data, "current_weather.weathercode")
+	if err != nil {
+		return 0, 0, err
+	}
+	temp, ok1 := tempVal.(float64)
+	codeF, ok2 := codeVal.(float64)
+	if !ok1 || !ok2 {
+		return 0, 0, fmt.Errorf("unexpected weather data format")
+	}
+	return temp, int(codeF), nil
+}
+
+// drawForecast displays a column for each day of the forecast, with the day, an icon for the
+// conditions and the high and low temperatures, the last forecast is kept if a fetch fails
+func drawForecast(ctx context.Context, wg *sync.WaitGroup, updateMu *sync.Mutex, cell CellT) {
+	showLoading(cell, updateMu)
+	days, err := fetchForecast(ctx, cell.Source)
+	if err != nil {
+		cellWarning(cell, "Could not get forecast from %s due to %s", cell.Source, err)
+	} else {
+		cell.forecast = days
+	}
+	if len(cell.forecast) == 0 {
+		return // nothing to show yet
+	}
+	stopAnimation(cell) // the loading placeholder, if any
+	if unchanged(cell, []byte(fmt.Sprint(cell.forecast))) {
+		return
+	}
+	bounds := cell.picture.Bounds()
+	colWidth := bounds.Dx() / len(cell.forecast)
+	textHeight := bounds.Dy() / 5
+	iconSize := bounds.Dy() - 2*textHeight
+	if iconSize > colWidth {
+		iconSize = colWidth
+	}
+	updateMu.Lock()
+	draw.Draw(cell.picture, bounds, image.Black, image.ZP, draw.Src)
+	for i, day := range cell.forecast {
+		left := i * colWidth
+		writeText(cell, cell.picture.SubImage(image.Rect(left, 0, left+colWidth, textHeight)).(draw.Image), day.day)
+		icon := weatherIcon(cell.IconDir, day.condition, iconSize, cell.AntiAlias)
+		iconLeft := left + (colWidth-iconSize)/2
+		iconTop := textHeight + (bounds.Dy()-2*textHeight-iconSize)/2
+		draw.Draw(cell.picture, image.Rect(iconLeft, iconTop, iconLeft+iconSize, iconTop+iconSize), icon, image.ZP, draw.Over)
+		temps := fmt.Sprintf("%.0f°/%.0f°", day.high, day.low)
+		writeText(cell, cell.picture.SubImage(image.Rect(left, bounds.Dy()-textHeight, left+colWidth, bounds.Dy())).(draw.Image), temps)
+	}
+	render(cell.positionRect, cell.picture)
+	updateMu.Unlock()
+}
+
+// fetchForecast returns the days of the daily forecast from an Open-Meteo URL
+func fetchForecast(ctx context.Context, url string) (days []forecastDayT, err error) {
+	body, err := fetchURL(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	var data interface{}
+	if err = json.Unmarshal(body, &data); err != nil {
+		return nil, err
+	}
+	var series [4][]interface{}
+	for i, path := range []string{"daily.time", "daily.weathercode", "daily.temperature_2m_max", "daily.temperature_2m_min"} {
+		val, err := jsonValue(data, path)
+		if err != nil {
+			return nil, err
+		}
+		var ok bool
+		if series[i], ok = val.([]interface{}); !ok || len(series[i]) != len(series[0]) {
+			return nil, fmt.Errorf("unexpected forecast data format")
+		}
+	}
+	for i := range series[0] {
+		date, ok1 := series[0][i].(string)
+		code, ok2 := series[1][i].(float64)
+		high, ok3 := series[2][i].(float64)
+		low, ok4 := series[3][i].(float64)
+		if !ok1 || !ok2 || !ok3 || !ok4 {
+			return nil, fmt.Errorf("unexpected forecast data format")
+		}
+		t, err := time.Parse("2006-01-02", date)
+		if err != nil {
+			return nil, err
+		}
+		days = append(days, forecastDayT{day: t.Format("Mon"), condition: weatherCondition(int(code)), high: high, low: low})
+	}
+	if len(days) == 0 {
+		return nil, fmt.Errorf("forecast has no days")
+	}
+	return days, nil
+}
+
+// weatherIcon returns a square icon for the given condition, taken from iconDir/<condition>.png
+// if that exists, otherwise the built-in icon is drawn (smoothly if antiAlias is set)
+func weatherIcon(iconDir, condition string, size int, antiAlias bool) image.Image {
+	if iconDir != "" {
+		fileName := filepath.Join(iconDir, condition+".png")
+		if f, err := os.Open(fileName); err == nil {
+			defer f.Close()
+			if img, _, err := image.Decode(f); err == nil {
+				return imaging.Fit(img, size, size, imaging.Lanczos)
+			}
+			log.Printf("WARNING: Could not decode weather icon %s", fileName)
+		}
+	}
+	if antiAlias {
+		return antiAliased(size, size, func(img *image.NRGBA) {
+			draw.Draw(img, img.Bounds(), builtinWeatherIcon(condition, img.Bounds().Dx()), image.ZP, draw.Src)
+		})
+	}
+	return builtinWeatherIcon(condition, size)
+}
+
+// builtinWeatherIcon draws a simple icon for the given condition
+func builtinWeatherIcon(condition string, size int) *image.NRGBA {
+	var (
+		icon      = image.NewNRGBA(image.Rect(0, 0, size, size))
+		sunColor  = color.RGBA{255, 200, 0, 255}
+		cloudGrey = color.RGBA{200, 200, 200, 255}
+		darkGrey  = color.RGBA{120, 120, 120, 255}
+		rainBlue  = color.RGBA{80, 140, 255, 255}
+		s         = float64(size)
+	)
+	switch condition {
+	case "clear":
+		fillCircle(icon, s/2, s/2, s*0.3, sunColor)
+	case "partlycloudy":
+		fillCircle(icon, s*0.62, s*0.38, s*0.22, sunColor)
+		drawCloud(icon, s, s*0.1, cloudGrey)
+	case "cloudy":
+		drawCloud(icon, s, 0, cloudGrey)
+	case "fog":
+		for i := 0; i < 4; i++ {
+			y := int(s * (0.3 + 0.13*float64(i)))
+			draw.Draw(icon, image.Rect(int(s*0.15), y, int(s*0.85), y+int(s*0.06)+1), image.NewUniform(cloudGrey), image.ZP, draw.Src)
+		}
+	case "rain":
+		drawCloud(icon, s, -s*0.1, darkGrey)
+		for i := 0; i < 3; i++ {
+			x := s * (0.3 + 0.2*float64(i))
+			drawLine(icon, x, s*0.65, x-s*0.07, s*0.85, s*0.03, rainBlue)
+		}
+	case "snow":
+		drawCloud(icon, s, -s*0.1, cloudGrey)
+		for i := 0; i < 3; i++ {
+			fillCircle(icon, s*(0.3+0.2*float64(i)), s*0.75, s*0.04, color.RGBA{255, 255, 255, 255})
+		}
+	case "thunder":
+		drawCloud(icon, s, -s*0.1, darkGrey)
+		drawLine(icon, s*0.55, s*0.55, s*0.45, s*0.72, s*0.04, sunColor)
+		drawLine(icon, s*0.45, s*0.72, s*0.56, s*0.72, s*0.04, sunColor)
+		drawLine(icon, s*0.56, s*0.72, s*0.46, s*0.9, s*0.04, sunColor)
+	}
+	return icon
+}
+
+// drawCloud draws a cloud across the middle of a square icon of size s, shifted down by dy
+func drawCloud(img *image.NRGBA, s, dy float64, col color.RGBA) {
+	fillCircle(img, s*0.35, s*0.55+dy, s*0.17, col)
+	fillCircle(img, s*0.55, s*0.47+dy, s*0.22, col)
+	fillCircle(img, s*0.72, s*0.58+dy, s*0.14, col)
+	draw.Draw(img, image.Rect(int(s*0.35), int(s*0.55+dy), int(s*0.72), int(s*0.72+dy)), image.NewUniform(col), image.ZP, draw.Src)
+}
+
+// fillCircle draws a solid circle
+func fillCircle(img draw.Image, cx, cy, r float64, col color.Color) {
+	for y := int(cy - r); y <= int(cy+r); y++ {
+		for x := int(cx - r); x <= int(cx+r); x++ {
+			if dx, dy := float64(x)-cx, float64(y)-cy; dx*dx+dy*dy <= r*r {
+				img.Set(x, y, col)
+			}
+		}
+	}
+}
+
+// drawLine draws a straight line of the given thickness by stamping circles along it
+func drawLine(img draw.Image, x0, y0, x1, y1, thickness float64, col color.Color) {
+	steps := int(math.Max(math.Abs(x1-x0), math.Abs(y1-y0))) + 1
+	for i := 0; i <= steps; i++ {
+		t := float64(i) / float64(steps)
+		fillCircle(img, x0+(x1-x0)*t, y0+(y1-y0)*t, thickness/2, col)
+	}
+}